@@ -3,17 +3,27 @@ package source
 import (
 	"archive/tar"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
 
 	"github.com/anchore/stereoscope/pkg/file"
 	"github.com/anchore/stereoscope/pkg/image"
+
+	"github.com/anchore/syft/internal/log"
 )
 
 var _ Resolver = (*AllLayersResolver)(nil)
 
+// defaultMaxSymlinkDepth bounds the number of hops that will be followed when resolving a chain of
+// symlinks/hardlinks, matching Linux's own MAXSYMLINKS limit.
+const defaultMaxSymlinkDepth = 40
+
 // AllLayersResolver implements path and content access for the AllLayers source option for container image data sources.
 type AllLayersResolver struct {
-	img    *image.Image
-	layers []int
+	img             *image.Image
+	layers          []int
+	maxSymlinkDepth int
 }
 
 // NewAllLayersResolver returns a new resolver from the perspective of all image layers for the given image.
@@ -27,13 +37,96 @@ func NewAllLayersResolver(img *image.Image) (*AllLayersResolver, error) {
 		layers = append(layers, idx)
 	}
 	return &AllLayersResolver{
-		img:    img,
-		layers: layers,
+		img:             img,
+		layers:          layers,
+		maxSymlinkDepth: defaultMaxSymlinkDepth,
 	}, nil
 }
 
-func (r *AllLayersResolver) fileByRef(ref file.Reference, uniqueFileIDs file.ReferenceSet, layerIdx int) ([]file.Reference, error) {
-	uniqueFiles := make([]file.Reference, 0)
+// resolvedLink captures the outcome of following a chain of symlinks/hardlinks to its terminal file: the final
+// reference, the path originally used to reach it, every virtual path visited along the way (starting with that
+// access path, joined by " -> "), and the index of the layer that introduced the final reference.
+type resolvedLink struct {
+	ref        file.Reference
+	accessPath string
+	chain      string
+	layerIdx   int
+}
+
+// resolveLinkChain follows a chain of symlinks/hardlinks starting at ref, re-resolving against the squashed view
+// of the image starting at layerIdx at each hop. It stops at the first non-link entry, whichever comes first, and
+// returns an error if the chain is dangling (a hop's target doesn't exist in this layer), cycles back to a path
+// already visited in this chain, or exceeds r.maxSymlinkDepth hops.
+func (r *AllLayersResolver) resolveLinkChain(ref file.Reference, layerIdx int) ([]file.Reference, error) {
+	return followLinkChain(ref, r.maxSymlinkDepth, func(current file.Reference) (next *file.Reference, isLink bool, err error) {
+		entry, err := r.img.FileCatalog.Get(current)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to fetch metadata (ref=%+v): %w", current, err)
+		}
+
+		if entry.Metadata.TypeFlag != tar.TypeLink && entry.Metadata.TypeFlag != tar.TypeSymlink {
+			return nil, false, nil
+		}
+
+		next, err = r.img.ResolveLinkByLayerSquash(current, layerIdx)
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to resolve link from layer (layer=%d ref=%+v): %w", layerIdx, current, err)
+		}
+		return next, true, nil
+	})
+}
+
+// followLinkChain walks a chain of symlinks/hardlinks starting at ref, using hop to inspect each entry visited
+// (whether it's a link, and if so, what it resolves to). It stops at the first non-link entry and returns an
+// error if the chain is dangling (a hop resolves to nil), cycles back to a reference already visited in this
+// chain, or exceeds maxDepth hops. Factored out of resolveLinkChain so the chain-walking logic itself (cycle
+// detection, depth limiting, dangling targets) can be unit tested without a real image.
+func followLinkChain(ref file.Reference, maxDepth int, hop func(current file.Reference) (next *file.Reference, isLink bool, err error)) ([]file.Reference, error) {
+	chain := []file.Reference{ref}
+	visited := file.NewFileReferenceSet(ref)
+
+	current := ref
+	// one extra iteration beyond maxDepth is needed to inspect the terminal entry of a chain of exactly maxDepth hops
+	for depth := 0; depth <= maxDepth; depth++ {
+		next, isLink, err := hop(current)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isLink {
+			return chain, nil
+		}
+		if next == nil {
+			return nil, fmt.Errorf("dangling link target while resolving link chain (start=%+v dangling=%+v)", ref, current)
+		}
+		if visited.Contains(*next) {
+			return nil, fmt.Errorf("cycle detected while resolving link chain (start=%+v repeat=%+v)", ref, *next)
+		}
+
+		visited.Add(*next)
+		chain = append(chain, *next)
+		current = *next
+	}
+
+	return nil, fmt.Errorf("max symlink depth (%d) exceeded while resolving link chain (start=%+v)", maxDepth, ref)
+}
+
+// chainToVirtualPathChain renders a resolved symlink/hardlink chain as a single " -> "-joined string (rather than
+// a []string) so that the resulting Location remains usable as a map key.
+func chainToVirtualPathChain(refs []file.Reference) string {
+	if len(refs) < 2 {
+		return ""
+	}
+	paths := make([]string, len(refs))
+	for i, ref := range refs {
+		paths[i] = string(ref.Path)
+	}
+	return strings.Join(paths, " -> ")
+}
+
+func (r *AllLayersResolver) fileByRef(ref file.Reference, layers []int, startPos int) ([]resolvedLink, error) {
+	accessPath := string(ref.Path)
+	links := make([]resolvedLink, 0)
 
 	// since there is potentially considerable work for each symlink/hardlink that needs to be resolved, let's check to see if this is a symlink/hardlink first
 	entry, err := r.img.FileCatalog.Get(ref)
@@ -43,32 +136,124 @@ func (r *AllLayersResolver) fileByRef(ref file.Reference, uniqueFileIDs file.Ref
 
 	if entry.Metadata.TypeFlag == tar.TypeLink || entry.Metadata.TypeFlag == tar.TypeSymlink {
 		// a link may resolve in this layer or higher, assuming a squashed tree is used to search
-		// we should search all possible resolutions within the valid source
-		for _, subLayerIdx := range r.layers[layerIdx:] {
-			resolvedRef, err := r.img.ResolveLinkByLayerSquash(ref, subLayerIdx)
+		// we should search all possible resolutions within the valid source, following each chain
+		// (which may itself cross layers) all the way to its terminal file
+		for _, subLayerIdx := range layers[startPos:] {
+			chain, err := r.resolveLinkChain(ref, subLayerIdx)
 			if err != nil {
-				return nil, fmt.Errorf("failed to resolve link from layer (layer=%d ref=%+v): %w", subLayerIdx, ref, err)
+				// an unresolvable symlink chain (dangling, a cycle, or one that exceeds the max depth) in
+				// one layer shouldn't cause the whole request to fail and take down every other path/pattern
+				// being resolved alongside it; skip just this layer's resolution and keep going.
+				log.Warnf("skipping unresolvable link chain (path=%q layer=%d): %v", accessPath, subLayerIdx, err)
+				continue
 			}
-			if resolvedRef != nil && !uniqueFileIDs.Contains(*resolvedRef) {
-				uniqueFileIDs.Add(*resolvedRef)
-				uniqueFiles = append(uniqueFiles, *resolvedRef)
+
+			resolvedRef := chain[len(chain)-1]
+			// attribute the result to the layer that introduced the terminal file, which may be lower than the
+			// layer whose squashed view was used to resolve the chain
+			resolvedEntry, err := r.img.FileCatalog.Get(resolvedRef)
+			if err != nil {
+				return nil, fmt.Errorf("unable to fetch metadata (ref=%+v): %w", resolvedRef, err)
 			}
+			resolvedLayerIdx := int(resolvedEntry.Source.Metadata.Index)
+			links = append(links, resolvedLink{ref: resolvedRef, accessPath: accessPath, chain: chainToVirtualPathChain(chain), layerIdx: resolvedLayerIdx})
 		}
-	} else if !uniqueFileIDs.Contains(ref) {
-		uniqueFileIDs.Add(ref)
-		uniqueFiles = append(uniqueFiles, ref)
+	} else {
+		links = append(links, resolvedLink{ref: ref, accessPath: accessPath, layerIdx: layers[startPos]})
 	}
 
-	return uniqueFiles, nil
+	return links, nil
+}
+
+func (r *AllLayersResolver) locationFromResolvedLink(result resolvedLink) Location {
+	location := NewLocationFromImage(result.ref, r.img)
+	location.VirtualPath = result.accessPath
+	location.VirtualPathChain = result.chain
+	return location.WithLayer(result.layerIdx, r.img.Layers[result.layerIdx].Metadata.Digest)
+}
+
+// locationAccumulator collects the Locations discovered while servicing a single FilesByPath/FilesByGlob(InLayers)
+// request, merging results that resolve to the same underlying content (the same file.Reference) into a single
+// Location instead of emitting duplicates. When the same content is reachable through more than one access path,
+// the additional paths are tracked separately and only folded into that Location's VirtualPaths once finalized
+// (VirtualPaths is a joined string, not a slice, so that Location remains usable as a map key while it's being
+// accumulated).
+type locationAccumulator struct {
+	locations       []Location
+	indexByRef      map[file.Reference]int
+	extraPathsByRef map[file.Reference][]string
+}
+
+func newLocationAccumulator() *locationAccumulator {
+	return &locationAccumulator{
+		indexByRef:      make(map[file.Reference]int),
+		extraPathsByRef: make(map[file.Reference][]string),
+	}
+}
+
+func (a *locationAccumulator) add(location Location) {
+	if _, ok := a.indexByRef[location.ref]; ok {
+		existing := a.locations[a.indexByRef[location.ref]]
+		if location.VirtualPath == existing.VirtualPath {
+			return
+		}
+		for _, p := range a.extraPathsByRef[location.ref] {
+			if p == location.VirtualPath {
+				return
+			}
+		}
+		a.extraPathsByRef[location.ref] = append(a.extraPathsByRef[location.ref], location.VirtualPath)
+		return
+	}
+
+	a.indexByRef[location.ref] = len(a.locations)
+	a.locations = append(a.locations, location)
+}
+
+// finalize folds the accumulated extra access paths into each Location's VirtualPaths field and returns the
+// results in deterministic order.
+func (a *locationAccumulator) finalize() []Location {
+	for ref, idx := range a.indexByRef {
+		if extra, ok := a.extraPathsByRef[ref]; ok {
+			a.locations[idx].VirtualPaths = strings.Join(extra, ", ")
+		}
+	}
+	sortLocations(a.locations)
+	return a.locations
+}
+
+// sortLocations orders locations deterministically by resolved (real) path and then by the lowest layer index that
+// introduced them, so that result ordering is stable across repeated runs against the same image rather than
+// depending on map iteration order in the underlying tree.
+func sortLocations(locations []Location) {
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].RealPath != locations[j].RealPath {
+			return locations[i].RealPath < locations[j].RealPath
+		}
+		return layerIndexOf(locations[i]) < layerIndexOf(locations[j])
+	})
+}
+
+func layerIndexOf(location Location) int {
+	if location.LayerIndex == nil {
+		return -1
+	}
+	return *location.LayerIndex
 }
 
 // FilesByPath returns all file.References that match the given paths from any layer in the image.
 func (r *AllLayersResolver) FilesByPath(paths ...string) ([]Location, error) {
-	uniqueFileIDs := file.NewFileReferenceSet()
-	uniqueLocations := make([]Location, 0)
+	return r.FilesByPathInLayers(r.layers, paths...)
+}
+
+// FilesByPathInLayers returns all file.References that match the given paths, searching only the given subset of
+// image layers (by layer index, searched in the given order). This allows callers to scope a search to, for
+// example, only the topmost layer added by a build step, without re-implementing layer iteration.
+func (r *AllLayersResolver) FilesByPathInLayers(layers []int, paths ...string) ([]Location, error) {
+	locations := newLocationAccumulator()
 
 	for _, path := range paths {
-		for idx, layerIdx := range r.layers {
+		for idx, layerIdx := range layers {
 			tree := r.img.Layers[layerIdx].Tree
 			ref := tree.File(file.Path(path))
 			if ref == nil {
@@ -89,31 +274,43 @@ func (r *AllLayersResolver) FilesByPath(paths ...string) ([]Location, error) {
 				}
 			}
 
-			results, err := r.fileByRef(*ref, uniqueFileIDs, idx)
+			results, err := r.fileByRef(*ref, layers, idx)
 			if err != nil {
 				return nil, err
 			}
 			for _, result := range results {
-				uniqueLocations = append(uniqueLocations, NewLocationFromImage(result, r.img))
+				locations.add(r.locationFromResolvedLink(result))
 			}
 		}
 	}
-	return uniqueLocations, nil
+	return locations.finalize(), nil
 }
 
 // FilesByGlob returns all file.References that match the given path glob pattern from any layer in the image.
 // nolint:gocognit
 func (r *AllLayersResolver) FilesByGlob(patterns ...string) ([]Location, error) {
-	uniqueFileIDs := file.NewFileReferenceSet()
-	uniqueLocations := make([]Location, 0)
+	return r.FilesByGlobInLayers(r.layers, patterns...)
+}
+
+// FilesByGlobInLayers returns all file.References that match the given path glob patterns, searching only the
+// given subset of image layers (by layer index, searched in the given order).
+// nolint:gocognit
+func (r *AllLayersResolver) FilesByGlobInLayers(layers []int, patterns ...string) ([]Location, error) {
+	locations := newLocationAccumulator()
 
 	for _, pattern := range patterns {
-		for idx, layerIdx := range r.layers {
+		for idx, layerIdx := range layers {
 			refs, err := r.img.Layers[layerIdx].Tree.FilesByGlob(pattern)
 			if err != nil {
 				return nil, fmt.Errorf("failed to resolve files by glob (%s): %w", pattern, err)
 			}
 
+			// the tree returns refs in an unspecified (map-iteration-based) order; sort them so that the
+			// order extra virtual paths are folded into a Location's VirtualPaths is stable across runs.
+			sort.Slice(refs, func(i, j int) bool {
+				return refs[i].Path < refs[j].Path
+			})
+
 			for _, ref := range refs {
 				// don't consider directories (special case: there is no path information for /)
 				if ref.Path == "/" {
@@ -128,18 +325,18 @@ func (r *AllLayersResolver) FilesByGlob(patterns ...string) ([]Location, error)
 					}
 				}
 
-				results, err := r.fileByRef(ref, uniqueFileIDs, idx)
+				results, err := r.fileByRef(ref, layers, idx)
 				if err != nil {
 					return nil, err
 				}
 				for _, result := range results {
-					uniqueLocations = append(uniqueLocations, NewLocationFromImage(result, r.img))
+					locations.add(r.locationFromResolvedLink(result))
 				}
 			}
 		}
 	}
 
-	return uniqueLocations, nil
+	return locations.finalize(), nil
 }
 
 // RelativeFileByPath fetches a single file at the given path relative to the layer squash of the given reference.
@@ -163,34 +360,59 @@ func (r *AllLayersResolver) RelativeFileByPath(location Location, path string) *
 // MultipleFileContentsByLocation returns the file contents for all file.References relative to the image. Note that a
 // file.Reference is a path relative to a particular layer.
 func (r *AllLayersResolver) MultipleFileContentsByLocation(locations []Location) (map[Location]string, error) {
-	return mapLocationRefs(r.img.MultipleFileContentsByRef, locations)
+	return mapLocationContents(r.FileContentsByLocationReader, locations)
 }
 
 // FileContentsByLocation fetches file contents for a single file reference, irregardless of the source layer.
-// If the path does not exist an error is returned.
+// If the path does not exist an error is returned. This is a thin wrapper around FileContentsByLocationReader
+// that fully buffers the contents in memory; prefer the reader variant for large files.
 func (r *AllLayersResolver) FileContentsByLocation(location Location) (string, error) {
-	return r.img.FileContentsByRef(location.ref)
-}
-
-type multiContentFetcher func(refs ...file.Reference) (map[file.Reference]string, error)
-
-func mapLocationRefs(callback multiContentFetcher, locations []Location) (map[Location]string, error) {
-	var fileRefs = make([]file.Reference, len(locations))
-	var locationByRefs = make(map[file.Reference]Location)
-	var results = make(map[Location]string)
+	reader, err := r.FileContentsByLocationReader(location)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
 
-	for i, location := range locations {
-		locationByRefs[location.ref] = location
-		fileRefs[i] = location.ref
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("unable to read contents (ref=%+v): %w", location.ref, err)
 	}
+	return string(contents), nil
+}
 
-	contentsByRef, err := callback(fileRefs...)
+// FileContentsByLocationReader fetches a stream of the file contents for a single file reference, irregardless of
+// the source layer, without buffering the entire file in memory. If the path does not exist an error is returned.
+func (r *AllLayersResolver) FileContentsByLocationReader(location Location) (io.ReadCloser, error) {
+	entry, err := r.img.FileCatalog.Get(location.ref)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("unable to get file metadata for location=%+v: %w", location, err)
 	}
+	return entry.Open()
+}
+
+type contentReaderFetcher func(Location) (io.ReadCloser, error)
+
+// mapLocationContents resolves the contents of each of the given locations via fetch, fully buffering each one in
+// memory. It is a thin convenience wrapper over the streaming fetcher for callers that want everything at once.
+func mapLocationContents(fetch contentReaderFetcher, locations []Location) (map[Location]string, error) {
+	results := make(map[Location]string, len(locations))
+
+	for _, location := range locations {
+		reader, err := fetch(location)
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := io.ReadAll(reader)
+		closeErr := reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read contents (ref=%+v): %w", location.ref, err)
+		}
+		if closeErr != nil {
+			return nil, fmt.Errorf("unable to close reader (ref=%+v): %w", location.ref, closeErr)
+		}
 
-	for ref, content := range contentsByRef {
-		results[locationByRefs[ref]] = content
+		results[location] = string(contents)
 	}
 	return results, nil
 }
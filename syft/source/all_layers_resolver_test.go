@@ -0,0 +1,197 @@
+package source
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/stereoscope/pkg/file"
+)
+
+func TestFollowLinkChain(t *testing.T) {
+	a := file.Reference{Path: "/a"}
+	b := file.Reference{Path: "/b"}
+	c := file.Reference{Path: "/c"}
+
+	t.Run("terminal non-link resolves immediately", func(t *testing.T) {
+		hop := func(current file.Reference) (*file.Reference, bool, error) {
+			return nil, false, nil
+		}
+
+		chain, err := followLinkChain(a, defaultMaxSymlinkDepth, hop)
+		require.NoError(t, err)
+		assert.Equal(t, []file.Reference{a}, chain)
+	})
+
+	t.Run("follows a multi-hop chain to its terminal file", func(t *testing.T) {
+		links := map[file.Reference]file.Reference{a: b, b: c}
+		hop := func(current file.Reference) (*file.Reference, bool, error) {
+			next, ok := links[current]
+			if !ok {
+				return nil, false, nil
+			}
+			return &next, true, nil
+		}
+
+		chain, err := followLinkChain(a, defaultMaxSymlinkDepth, hop)
+		require.NoError(t, err)
+		assert.Equal(t, []file.Reference{a, b, c}, chain)
+	})
+
+	t.Run("cycle is detected and returns an error", func(t *testing.T) {
+		links := map[file.Reference]file.Reference{a: b, b: a}
+		hop := func(current file.Reference) (*file.Reference, bool, error) {
+			next := links[current]
+			return &next, true, nil
+		}
+
+		_, err := followLinkChain(a, defaultMaxSymlinkDepth, hop)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cycle detected")
+	})
+
+	t.Run("exceeding the max depth returns an error", func(t *testing.T) {
+		hop := func(current file.Reference) (*file.Reference, bool, error) {
+			// every hop resolves to a never-before-seen path, so this only terminates via the depth limit
+			next := file.Reference{Path: file.Path(string(current.Path) + "x")}
+			return &next, true, nil
+		}
+
+		_, err := followLinkChain(a, 3, hop)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "max symlink depth")
+	})
+
+	t.Run("a dangling link target returns an error", func(t *testing.T) {
+		hop := func(current file.Reference) (*file.Reference, bool, error) {
+			return nil, true, nil
+		}
+
+		_, err := followLinkChain(a, defaultMaxSymlinkDepth, hop)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "dangling link target")
+	})
+
+	t.Run("a hop error is propagated", func(t *testing.T) {
+		hopErr := errors.New("boom")
+		hop := func(current file.Reference) (*file.Reference, bool, error) {
+			return nil, false, hopErr
+		}
+
+		_, err := followLinkChain(a, defaultMaxSymlinkDepth, hop)
+		assert.ErrorIs(t, err, hopErr)
+	})
+}
+
+func TestChainToVirtualPathChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		refs     []file.Reference
+		expected string
+	}{
+		{
+			name:     "no refs",
+			refs:     nil,
+			expected: "",
+		},
+		{
+			name:     "single ref",
+			refs:     []file.Reference{{Path: "/a"}},
+			expected: "",
+		},
+		{
+			name:     "chain of links",
+			refs:     []file.Reference{{Path: "/a"}, {Path: "/b"}, {Path: "/c"}},
+			expected: "/a -> /b -> /c",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, chainToVirtualPathChain(test.refs))
+		})
+	}
+}
+
+type closeErrReader struct {
+	io.Reader
+	closeErr error
+}
+
+func (c closeErrReader) Close() error {
+	return c.closeErr
+}
+
+func TestMapLocationContents(t *testing.T) {
+	a := Location{RealPath: "/a"}
+	b := Location{RealPath: "/b"}
+
+	t.Run("buffers contents for each location", func(t *testing.T) {
+		fetch := func(l Location) (io.ReadCloser, error) {
+			return closeErrReader{Reader: bytes.NewBufferString(l.RealPath + "-contents")}, nil
+		}
+
+		results, err := mapLocationContents(fetch, []Location{a, b})
+		require.NoError(t, err)
+		assert.Equal(t, "/a-contents", results[a])
+		assert.Equal(t, "/b-contents", results[b])
+	})
+
+	t.Run("propagates a reader close error", func(t *testing.T) {
+		closeErr := errors.New("close failed")
+		fetch := func(l Location) (io.ReadCloser, error) {
+			return closeErrReader{Reader: bytes.NewBufferString("contents"), closeErr: closeErr}, nil
+		}
+
+		_, err := mapLocationContents(fetch, []Location{a})
+		assert.ErrorIs(t, err, closeErr)
+	})
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestSortLocations(t *testing.T) {
+	locations := []Location{
+		{RealPath: "/b", LayerIndex: intPtr(0)},
+		{RealPath: "/a", LayerIndex: intPtr(1)},
+		{RealPath: "/a", LayerIndex: intPtr(0)},
+		{RealPath: "/c"},
+	}
+
+	sortLocations(locations)
+
+	expected := []string{"/a", "/a", "/b", "/c"}
+	actual := make([]string, len(locations))
+	for i, l := range locations {
+		actual[i] = l.RealPath
+	}
+	assert.Equal(t, expected, actual)
+
+	// ties on RealPath are broken by the lowest layer index
+	assert.Equal(t, 0, *locations[0].LayerIndex)
+	assert.Equal(t, 1, *locations[1].LayerIndex)
+}
+
+func TestLocationAccumulator(t *testing.T) {
+	ref1 := file.Reference{Path: "/real/a"}
+	ref2 := file.Reference{Path: "/real/b"}
+
+	acc := newLocationAccumulator()
+	acc.add(Location{RealPath: "/real/a", VirtualPath: "/access/a", ref: ref1})
+	// a second access path resolving to the same content should be folded into VirtualPaths, not duplicated
+	acc.add(Location{RealPath: "/real/a", VirtualPath: "/access/a2", ref: ref1})
+	// re-adding the same access path for the same content should not produce a duplicate entry either
+	acc.add(Location{RealPath: "/real/a", VirtualPath: "/access/a", ref: ref1})
+	acc.add(Location{RealPath: "/real/b", VirtualPath: "/access/b", ref: ref2})
+
+	results := acc.finalize()
+
+	require.Len(t, results, 2)
+	assert.Equal(t, "/access/a2", results[0].VirtualPaths)
+	assert.Equal(t, "", results[1].VirtualPaths)
+}
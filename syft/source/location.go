@@ -0,0 +1,51 @@
+package source
+
+import (
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/image"
+)
+
+// Location represents a path relative to a particular filesystem resolved to a single file. This struct is used as
+// a key in content fetching to uniquely identify a file relative to a request.
+type Location struct {
+	// RealPath is the path to the file after all symlinks/hardlinks have been resolved.
+	RealPath string `json:"path"`
+	// VirtualPath is the path originally used to access this location, which may itself be a symlink.
+	VirtualPath string `json:"accessPath,omitempty"`
+	// VirtualPathChain records every path visited while resolving a chain of symlinks/hardlinks, starting with
+	// VirtualPath and ending with RealPath, joined by " -> ". It is only populated when the location was reached
+	// through one or more intermediate links. Stored as a string (rather than []string) so that Location remains
+	// usable as a map key.
+	VirtualPathChain string `json:"virtualPathChain,omitempty"`
+	// VirtualPaths records additional access paths (beyond VirtualPath), joined by ", ", that were found to resolve
+	// to this exact same content within a single resolver query. It is only populated when a search turns up more
+	// than one path leading to the same underlying file, so that callers can see the full set without the result
+	// set containing duplicate, near-identical Locations. Stored as a string (rather than []string) so that
+	// Location remains usable as a map key.
+	VirtualPaths string `json:"virtualPaths,omitempty"`
+	// LayerIndex is the index (into the image's layers) of the layer that introduced this location. It is only
+	// populated by image-backed resolvers.
+	LayerIndex *int `json:"layerIndex,omitempty"`
+	// LayerDigest is the digest of the layer that introduced this location. It is only populated by image-backed
+	// resolvers.
+	LayerDigest string `json:"layerDigest,omitempty"`
+
+	ref file.Reference
+}
+
+// NewLocationFromImage creates a new Location representing the given path (accessed via ref) relative to the
+// given image.
+func NewLocationFromImage(ref file.Reference, img *image.Image) Location {
+	return Location{
+		RealPath:    string(ref.Path),
+		VirtualPath: string(ref.Path),
+		ref:         ref,
+	}
+}
+
+// WithLayer returns a copy of this Location annotated with the index and digest of the image layer that introduced it.
+func (l Location) WithLayer(layerIdx int, layerDigest string) Location {
+	l.LayerIndex = &layerIdx
+	l.LayerDigest = layerDigest
+	return l
+}
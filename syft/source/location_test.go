@@ -0,0 +1,17 @@
+package source
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocationWithLayer(t *testing.T) {
+	location := Location{RealPath: "/a"}
+
+	result := location.WithLayer(3, "sha256:deadbeef")
+
+	assert.Equal(t, 3, *result.LayerIndex)
+	assert.Equal(t, "sha256:deadbeef", result.LayerDigest)
+	assert.Nil(t, location.LayerIndex, "WithLayer should not mutate the receiver")
+}
@@ -0,0 +1,36 @@
+package source
+
+import "io"
+
+// Resolver is the main interface to file system path and metadata resolution.
+type Resolver interface {
+	FileContentResolver
+	FilePathResolver
+}
+
+// FileContentResolver knows how to fetch file contents for a given Location.
+type FileContentResolver interface {
+	// FileContentsByLocation fetches the entire contents of the file at the given location, fully buffered in memory.
+	FileContentsByLocation(Location) (string, error)
+	// FileContentsByLocationReader fetches the contents of the file at the given location as a stream, without
+	// buffering the entire file in memory. Callers are responsible for closing the returned reader.
+	FileContentsByLocationReader(Location) (io.ReadCloser, error)
+	// MultipleFileContentsByLocation fetches the entire contents of each of the given locations, fully buffered in memory.
+	MultipleFileContentsByLocation([]Location) (map[Location]string, error)
+}
+
+// FilePathResolver knows how to find files and locations by path and glob within an underlying source.
+type FilePathResolver interface {
+	// FilesByPath returns all Locations that match the given paths within the underlying source.
+	FilesByPath(paths ...string) ([]Location, error)
+	// FilesByGlob returns all Locations that match the given glob patterns within the underlying source.
+	FilesByGlob(patterns ...string) ([]Location, error)
+	// FilesByPathInLayers returns all Locations that match the given paths, searching only the given subset of
+	// layers (by layer index, searched in the given order).
+	FilesByPathInLayers(layers []int, paths ...string) ([]Location, error)
+	// FilesByGlobInLayers returns all Locations that match the given glob patterns, searching only the given
+	// subset of layers (by layer index, searched in the given order).
+	FilesByGlobInLayers(layers []int, patterns ...string) ([]Location, error)
+	// RelativeFileByPath fetches a single Location for the given path relative to the layer/tree of the given Location.
+	RelativeFileByPath(_ Location, path string) *Location
+}